@@ -0,0 +1,153 @@
+// 	Copyright 2017, Google, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// overflowPolicy controls what entryQueue does when its buffer is full.
+type overflowPolicy int
+
+const (
+	overflowBlock overflowPolicy = iota
+	overflowDropOldest
+)
+
+func parseOverflowPolicy(s string) (overflowPolicy, error) {
+	switch s {
+	case "block":
+		return overflowBlock, nil
+	case "drop-oldest":
+		return overflowDropOldest, nil
+	default:
+		return 0, fmt.Errorf("invalid --overflow %q, want block or drop-oldest", s)
+	}
+}
+
+// entryQueue is a bounded, channel-backed queue of log entries that sits
+// between the input scanner and the configured sinks, so a slow backend
+// applies backpressure (or sheds load) instead of stdin reads blocking
+// silently forever.
+type entryQueue struct {
+	ch      chan logging.Entry
+	policy  overflowPolicy
+	dropped uint64
+}
+
+func newEntryQueue(size int, policy overflowPolicy) *entryQueue {
+	return &entryQueue{ch: make(chan logging.Entry, size), policy: policy}
+}
+
+// push adds an entry to the queue, applying the configured overflow policy
+// if the queue is full.
+func (q *entryQueue) push(e logging.Entry) {
+	if q.policy == overflowBlock {
+		q.ch <- e
+		return
+	}
+	select {
+	case q.ch <- e:
+		return
+	default:
+	}
+	// Queue is full: drop the oldest entry to make room for this one.
+	select {
+	case <-q.ch:
+		n := atomic.AddUint64(&q.dropped, 1)
+		fmt.Fprintf(os.Stderr, "logpipe: buffer full, dropped oldest entry (%d dropped total)\n", n)
+	default:
+	}
+	select {
+	case q.ch <- e:
+	default:
+		// Another goroutine raced us for the freed slot; drop this one too.
+		n := atomic.AddUint64(&q.dropped, 1)
+		fmt.Fprintf(os.Stderr, "logpipe: buffer full, dropped incoming entry (%d dropped total)\n", n)
+	}
+}
+
+func (q *entryQueue) close() { close(q.ch) }
+
+func (q *entryQueue) droppedCount() uint64 { return atomic.LoadUint64(&q.dropped) }
+
+// truncatePayload cuts a text entry's payload to its first maxSize bytes and
+// appends a "...(truncated)" marker, so a single runaway line can't balloon
+// memory or a single logging API request. It is a
+// no-op for non-string payloads and when maxSize is unset.
+func truncatePayload(e logging.Entry, maxSize int) logging.Entry {
+	if maxSize <= 0 {
+		return e
+	}
+	s, ok := e.Payload.(string)
+	if !ok || len(s) <= maxSize {
+		return e
+	}
+	e.Payload = s[:maxSize] + "...(truncated)"
+	return e
+}
+
+// defaultScanBufferSize is used when --max-entry-size is 0 (truncation
+// disabled): bufio.Scanner still needs a finite cap on a single line.
+const defaultScanBufferSize = 1024 * 1024
+
+// scanBufferHeadroom pads the scanner's max token size past maxEntrySize so
+// truncatePayload, not bufio.Scanner, is what decides a long line's fate.
+const scanBufferHeadroom = 4096
+
+// scannerMaxTokenSize returns the bufio.Scanner max token size to pair with
+// --max-entry-size, so a single line longer than the scanner's default 64KB
+// limit gets truncated by truncatePayload instead of aborting the scan with
+// bufio.ErrTooLong and silently killing the rest of the stream.
+func scannerMaxTokenSize(maxEntrySize int) int {
+	size := maxEntrySize
+	if size <= 0 {
+		size = defaultScanBufferSize
+	}
+	if size < bufio.MaxScanTokenSize {
+		size = bufio.MaxScanTokenSize
+	}
+	return size + scanBufferHeadroom
+}
+
+// backoff computes exponential retry delays with jitter, used to pace
+// reconnect/retry attempts after the logging client reports an error so a
+// persistently failing backend doesn't spin.
+type backoff struct {
+	base, max time.Duration
+	attempt   int
+}
+
+func newBackoff(base, max time.Duration) *backoff {
+	return &backoff{base: base, max: max}
+}
+
+func (b *backoff) next() time.Duration {
+	d := b.base << uint(b.attempt)
+	if d <= 0 || d > b.max {
+		d = b.max
+	}
+	b.attempt++
+	// Full jitter: a random delay between 0 and d.
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+func (b *backoff) reset() { b.attempt = 0 }
@@ -0,0 +1,138 @@
+// 	Copyright 2017, Google, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestParseOverflowPolicy(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    overflowPolicy
+		wantErr bool
+	}{
+		{s: "block", want: overflowBlock},
+		{s: "drop-oldest", want: overflowDropOldest},
+		{s: "garbage", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseOverflowPolicy(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseOverflowPolicy(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("parseOverflowPolicy(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestEntryQueueDropOldest(t *testing.T) {
+	q := newEntryQueue(2, overflowDropOldest)
+	payload := func(e logging.Entry) string { return e.Payload.(string) }
+
+	q.push(logging.Entry{Payload: "1"})
+	q.push(logging.Entry{Payload: "2"})
+	// Queue is now full; pushing "3" should drop "1" and keep "2", "3".
+	q.push(logging.Entry{Payload: "3"})
+
+	if got := q.droppedCount(); got != 1 {
+		t.Fatalf("droppedCount() = %d, want 1", got)
+	}
+	q.close()
+	var got []string
+	for e := range q.ch {
+		got = append(got, payload(e))
+	}
+	want := []string{"2", "3"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("drained entries = %v, want %v", got, want)
+	}
+}
+
+func TestEntryQueueBlockDoesNotDrop(t *testing.T) {
+	q := newEntryQueue(1, overflowBlock)
+	q.push(logging.Entry{Payload: "1"})
+	done := make(chan struct{})
+	go func() {
+		q.push(logging.Entry{Payload: "2"})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("push on a full block-policy queue returned before the queue was drained")
+	case <-time.After(20 * time.Millisecond):
+	}
+	<-q.ch // drain to unblock the goroutine
+	<-done
+	if got := q.droppedCount(); got != 0 {
+		t.Errorf("droppedCount() = %d, want 0", got)
+	}
+}
+
+func TestTruncatePayload(t *testing.T) {
+	e := logging.Entry{Payload: "hello world"}
+	got := truncatePayload(e, 5)
+	want := "hello...(truncated)"
+	if got.Payload != want {
+		t.Errorf("Payload = %q, want %q", got.Payload, want)
+	}
+
+	// maxSize <= 0 disables truncation.
+	if got := truncatePayload(e, 0); got.Payload != e.Payload {
+		t.Errorf("Payload = %q, want unchanged %q", got.Payload, e.Payload)
+	}
+
+	// Non-string payloads are left alone.
+	structured := logging.Entry{Payload: map[string]interface{}{"a": "b"}}
+	if got := truncatePayload(structured, 1); got.Payload.(map[string]interface{})["a"] != "b" {
+		t.Errorf("non-string payload was modified: %#v", got.Payload)
+	}
+}
+
+func TestScannerMaxTokenSize(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxEntrySize int
+		want         int
+	}{
+		{"disabled falls back to default", 0, defaultScanBufferSize + scanBufferHeadroom},
+		{"below scanner default is bumped up", 1024, bufio.MaxScanTokenSize + scanBufferHeadroom},
+		{"above scanner default is honored", 4 * 1024 * 1024, 4*1024*1024 + scanBufferHeadroom},
+	}
+	for _, tt := range tests {
+		if got := scannerMaxTokenSize(tt.maxEntrySize); got != tt.want {
+			t.Errorf("scannerMaxTokenSize(%d) = %d, want %d", tt.maxEntrySize, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(10*time.Millisecond, 100*time.Millisecond)
+	for i := 0; i < 10; i++ {
+		d := b.next()
+		if d < 0 || d > 100*time.Millisecond {
+			t.Fatalf("next() = %v, want within [0, 100ms]", d)
+		}
+	}
+	b.reset()
+	if b.attempt != 0 {
+		t.Errorf("attempt = %d after reset, want 0", b.attempt)
+	}
+}
@@ -0,0 +1,247 @@
+// 	Copyright 2017, Google, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	flags "github.com/jessevdk/go-flags"
+
+	"cloud.google.com/go/logging"
+	"golang.org/x/net/context"
+)
+
+// runCommand implements `logpipe run -- <cmd> [args...]`. It forks the given
+// command, tees its stdout and stderr back to this process's terminal, and
+// ships each stream to the configured sinks as its own logging.Entry stream, then
+// forwards the child's exit code.
+func runCommand(args []string) int {
+	var opts struct {
+		ProjectID           string        `short:"p" long:"project" description:"Google Cloud Platform Project ID; detected from GCE/GKE/Cloud Run metadata if omitted"`
+		LogName             string        `short:"l" long:"logname" description:"The name of the log to write to" default:"default"`
+		ResourceType        string        `long:"resource-type" description:"Override the auto-detected MonitoredResource type, e.g. gce_instance, k8s_container, cloud_run_revision"`
+		ResourceLabels      []string      `long:"resource-label" description:"Override or add a resource label as k=v; may be given multiple times"`
+		BufferSize          int           `long:"buffer-size" description:"Number of entries to buffer between the child process and the logging client before applying the overflow policy" default:"1000"`
+		FlushInterval       time.Duration `long:"flush-interval" description:"Maximum time entries may sit buffered before being sent" default:"1s"`
+		MaxEntrySize        int           `long:"max-entry-size" description:"Truncate a single entry's payload to this many bytes; 0 disables truncation" default:"262144"`
+		Overflow            string        `long:"overflow" description:"What to do when the buffer is full" default:"block" choice:"block" choice:"drop-oldest"`
+		EntryCountThreshold int           `long:"entry-count-threshold" description:"Flush after this many entries accumulate" default:"1000"`
+		EntryByteThreshold  int           `long:"entry-byte-threshold" description:"Flush after this many bytes of entries accumulate" default:"1048576"`
+		BufferedByteLimit   int           `long:"buffered-byte-limit" description:"Maximum bytes the logging client may buffer; entries past this limit are dropped and reported as overflow errors" default:"8388608"`
+		Format              string        `short:"f" long:"format" description:"Format of each captured line: text, json, or logfmt" default:"text" choice:"text" choice:"json" choice:"logfmt"`
+		SeverityMap         string        `long:"severity-map" description:"Comma-separated level=SEVERITY mappings applied to the severity/level field, e.g. warn=WARNING,err=ERROR"`
+		Sinks               []string      `long:"sink" description:"Where to deliver entries: stackdriver, file:<path>, syslog, stdout, stderr, or dry-run; may be given multiple times" default:"stackdriver"`
+		MultilineRegex      string        `long:"multiline-regex" description:"Regex matching lines that continue the previous record, e.g. leading whitespace or 'Caused by:'"`
+		Multiline           string        `long:"multiline" description:"Use a preset continuation pattern instead of --multiline-regex" choice:"java" choice:"python" choice:"go-panic"`
+		MultilineTimeout    time.Duration `long:"multiline-timeout" description:"Flush a pending multiline record if no continuation line arrives within this long" default:"200ms"`
+	}
+	rest, err := flags.ParseArgs(&opts, args)
+	if err != nil {
+		return 2
+	}
+	if len(rest) == 0 {
+		errorf("run: no command given, expected `logpipe run -- <cmd> [args...]`")
+	}
+
+	overflow, err := parseOverflowPolicy(opts.Overflow)
+	if err != nil {
+		errorf("%v", err)
+	}
+	sevMap, err := parseSeverityMap(opts.SeverityMap)
+	if err != nil {
+		errorf("Invalid --severity-map: %v", err)
+	}
+	mlRule, err := resolveMultilineRule(opts.MultilineRegex, opts.Multiline)
+	if err != nil {
+		errorf("Invalid --multiline-regex: %v", err)
+	}
+
+	// Only stand up a Stackdriver client if a configured sink actually needs
+	// one, matching the pipe mode's behavior.
+	ctx := context.Background()
+	var client *logging.Client
+	var logger *logging.Logger
+	if needsStackdriver(opts.Sinks) {
+		projectID, err := resolveProjectID(opts.ProjectID)
+		if err != nil {
+			errorf("%v", err)
+		}
+		resource, commonLabels := detectResource(opts.ResourceType, opts.ResourceLabels)
+
+		client, err = logging.NewClient(ctx, projectID)
+		if err != nil {
+			errorf("Failed to create client: %v", err)
+		}
+		errc := make(chan error)
+		client.OnError = func(err error) { errc <- err }
+		go logErrors(errc)
+
+		// Explicit buffering thresholds, matching the pipe mode's logger
+		// construction, so a slow Stackdriver backend applies backpressure
+		// here too instead of blocking scanStream's scan of the child's
+		// output.
+		loggerOpts := []logging.LoggerOption{
+			logging.EntryCountThreshold(opts.EntryCountThreshold),
+			logging.EntryByteThreshold(opts.EntryByteThreshold),
+			logging.DelayThreshold(opts.FlushInterval),
+			logging.BufferedByteLimit(opts.BufferedByteLimit),
+		}
+		if resource != nil {
+			loggerOpts = append(loggerOpts, logging.CommonResource(resource))
+		}
+		if commonLabels != nil {
+			loggerOpts = append(loggerOpts, logging.CommonLabels(commonLabels))
+		}
+		logger = client.Logger(opts.LogName, loggerOpts...)
+	}
+
+	sinkList, err := newSinks(opts.Sinks, logger)
+	if err != nil {
+		errorf("%v", err)
+	}
+	sink := &fanOutSink{sinks: sinkList}
+
+	child := exec.Command(rest[0], rest[1:]...)
+	child.Stdin = os.Stdin
+
+	stdout, err := child.StdoutPipe()
+	if err != nil {
+		errorf("Failed to open child stdout: %v", err)
+	}
+	stderr, err := child.StderrPipe()
+	if err != nil {
+		errorf("Failed to open child stderr: %v", err)
+	}
+
+	if err := child.Start(); err != nil {
+		errorf("Failed to start %q: %v", rest[0], err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for s := range signals {
+			if child.Process != nil {
+				child.Process.Signal(s)
+			}
+		}
+	}()
+
+	queue := newEntryQueue(opts.BufferSize, overflow)
+	queueDone := make(chan struct{})
+	go func() {
+		defer close(queueDone)
+		for entry := range queue.ch {
+			sink.Write(entry)
+		}
+	}()
+
+	rawStdout := scanStream(stdout, os.Stdout, opts.MaxEntrySize, "stdout")
+	rawStderr := scanStream(stderr, os.Stderr, opts.MaxEntrySize, "stderr")
+
+	// Each stream gets its own assembler instance so a continuation in
+	// stdout can't absorb lines from stderr or vice versa.
+	stdoutRecords, stderrRecords := rawStdout, rawStderr
+	if mlRule != nil {
+		stdoutRecords = newMultilineAssembler(mlRule, opts.MultilineTimeout).run(rawStdout)
+		stderrRecords = newMultilineAssembler(mlRule, opts.MultilineTimeout).run(rawStderr)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go ingestStream(&wg, stdoutRecords, queue, opts.Format, sevMap, opts.MaxEntrySize, "stdout", logging.Info)
+	go ingestStream(&wg, stderrRecords, queue, opts.Format, sevMap, opts.MaxEntrySize, "stderr", logging.Error)
+	wg.Wait()
+	queue.close()
+	<-queueDone
+
+	if n := queue.droppedCount(); n > 0 {
+		fmt.Fprintf(os.Stderr, "logpipe: %d entries dropped due to buffer overflow\n", n)
+	}
+
+	waitErr := child.Wait()
+	signal.Stop(signals)
+	close(signals)
+
+	if err := sink.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "logpipe: sink close failed: %v\n", err)
+	}
+	if client != nil {
+		if err := client.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to close client: %v\n", err)
+		}
+	}
+
+	if exitErr, ok := waitErr.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	if waitErr != nil {
+		fmt.Fprintf(os.Stderr, "Failed to run %q: %v\n", rest[0], waitErr)
+		return 1
+	}
+	return 0
+}
+
+// scanStream copies lines from r to w (the parent's terminal) and returns a
+// channel of the raw lines, closed once r is exhausted. Splitting the scan
+// from entry construction lets the caller run the lines through a
+// multilineAssembler before they become logging.Entry values.
+func scanStream(r io.Reader, w io.Writer, maxEntrySize int, stream string) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 0, 64*1024), scannerMaxTokenSize(maxEntrySize))
+		for s.Scan() {
+			line := s.Text()
+			fmt.Fprintln(w, line)
+			out <- line
+		}
+		if err := s.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to scan %s: %v\n", stream, err)
+		}
+	}()
+	return out
+}
+
+// ingestStream parses each record per --format, labels it with the stream it
+// came from, and pushes it onto the entry queue. It returns once records is
+// closed and drained.
+func ingestStream(wg *sync.WaitGroup, records <-chan string, queue *entryQueue, format string, sevMap map[string]logging.Severity, maxEntrySize int, stream string, defaultSeverity logging.Severity) {
+	defer wg.Done()
+	for record := range records {
+		entry := withStreamLabel(parseEntry(format, record, defaultSeverity, sevMap), stream)
+		queue.push(truncatePayload(entry, maxEntrySize))
+	}
+}
+
+// withStreamLabel returns a copy of e with its "stream" label set, leaving
+// any other labels (e.g. from structured --format parsing) untouched.
+func withStreamLabel(e logging.Entry, stream string) logging.Entry {
+	labels := make(map[string]string, len(e.Labels)+1)
+	for k, v := range e.Labels {
+		labels[k] = v
+	}
+	labels["stream"] = stream
+	e.Labels = labels
+	return e
+}
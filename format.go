@@ -0,0 +1,229 @@
+// 	Copyright 2017, Google, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// Well-known keys that structured log lines use to carry metadata that
+// Stackdriver understands natively. Anything left over becomes the entry
+// payload.
+var (
+	severityKeys = []string{"severity", "level"}
+	timeKeys     = []string{"time", "ts", "timestamp"}
+	traceKeys    = []string{"trace"}
+	spanIDKeys   = []string{"spanID", "span_id"}
+	labelsKeys   = []string{"labels"}
+)
+
+// parseSeverityMap parses a `--severity-map` value such as
+// "warn=WARNING,err=ERROR" into a lookup table from an arbitrary level
+// string (lower-cased) to a logging.Severity.
+func parseSeverityMap(s string) (map[string]logging.Severity, error) {
+	m := map[string]logging.Severity{}
+	if s == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid severity-map entry %q, want level=SEVERITY", pair)
+		}
+		m[strings.ToLower(strings.TrimSpace(kv[0]))] = logging.ParseSeverity(strings.TrimSpace(kv[1]))
+	}
+	return m, nil
+}
+
+// parseEntry turns a single line of stdin into a logging.Entry according to
+// the configured --format. For "text" the line becomes the raw payload; for
+// "json" and "logfmt" the line is parsed into a map and well-known fields are
+// promoted to their corresponding logging.Entry fields.
+func parseEntry(format, line string, defaultSeverity logging.Severity, sevMap map[string]logging.Severity) logging.Entry {
+	switch format {
+	case "json":
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			// Not valid JSON; fall back to treating the whole line as text
+			// rather than dropping it.
+			return logging.Entry{Payload: line, Severity: defaultSeverity}
+		}
+		return entryFromFields(fields, defaultSeverity, sevMap)
+	case "logfmt":
+		return entryFromFields(parseLogfmt(line), defaultSeverity, sevMap)
+	default:
+		return logging.Entry{Payload: line, Severity: defaultSeverity}
+	}
+}
+
+// entryFromFields extracts well-known keys from a parsed structured log line
+// and builds a logging.Entry whose Payload is whatever fields remain.
+func entryFromFields(fields map[string]interface{}, defaultSeverity logging.Severity, sevMap map[string]logging.Severity) logging.Entry {
+	entry := logging.Entry{Severity: defaultSeverity, Payload: fields}
+
+	if v, key := popString(fields, severityKeys); key != "" {
+		if sev, ok := sevMap[strings.ToLower(v)]; ok {
+			entry.Severity = sev
+		} else {
+			entry.Severity = logging.ParseSeverity(v)
+		}
+	}
+	if v, key := popString(fields, timeKeys); key != "" {
+		if t, err := parseTime(v); err == nil {
+			entry.Timestamp = t
+		} else {
+			// Couldn't parse it; leave it in the payload for the user to see.
+			fields[key] = v
+		}
+	}
+	if v, key := popString(fields, traceKeys); key != "" {
+		entry.Trace = v
+	}
+	if v, key := popString(fields, spanIDKeys); key != "" {
+		entry.SpanID = v
+	}
+	for _, key := range labelsKeys {
+		if v, ok := fields[key]; ok {
+			if labels, ok := toStringMap(v); ok {
+				entry.Labels = labels
+				delete(fields, key)
+			}
+		}
+	}
+	return entry
+}
+
+// popString looks up the first of the given keys present in fields, removes
+// it, and returns its string value along with the key that matched. It
+// returns an empty key if none of the candidates were present.
+func popString(fields map[string]interface{}, keys []string) (string, string) {
+	for _, key := range keys {
+		v, ok := fields[key]
+		if !ok {
+			continue
+		}
+		delete(fields, key)
+		switch t := v.(type) {
+		case string:
+			return t, key
+		case fmt.Stringer:
+			return t.String(), key
+		default:
+			return fmt.Sprintf("%v", t), key
+		}
+	}
+	return "", ""
+}
+
+func toStringMap(v interface{}) (map[string]string, bool) {
+	switch m := v.(type) {
+	case map[string]string:
+		return m, true
+	case map[string]interface{}:
+		out := make(map[string]string, len(m))
+		for k, val := range m {
+			out[k] = fmt.Sprintf("%v", val)
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// parseTime accepts the handful of timestamp formats structured loggers
+// commonly emit: RFC3339(Nano), and Unix seconds (with optional fraction).
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec := int64(f)
+		nsec := int64((f - float64(sec)) * 1e9)
+		return time.Unix(sec, nsec), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time format %q", s)
+}
+
+// parseLogfmt parses a single logfmt-encoded line (key=value pairs,
+// optionally double-quoted, separated by spaces) into a map. Bare words
+// without a "=" are collected under the "msg" key, matching the common
+// convention of a leading free-text message.
+func parseLogfmt(line string) map[string]interface{} {
+	fields := map[string]interface{}{}
+	var msgParts []string
+
+	i := 0
+	n := len(line)
+	for i < n {
+		for i < n && line[i] == ' ' {
+			i++
+		}
+		if i >= n {
+			break
+		}
+		start := i
+		for i < n && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[start:i]
+		if i >= n || line[i] != '=' {
+			// No "=" follows; this is a bare word, not a key=value pair.
+			msgParts = append(msgParts, key)
+			continue
+		}
+		i++ // skip '='
+		var value string
+		if i < n && line[i] == '"' {
+			i++
+			valStart := i
+			for i < n && line[i] != '"' {
+				if line[i] == '\\' && i+1 < n {
+					i++
+				}
+				i++
+			}
+			quoted := line[valStart:i]
+			if i < n {
+				i++ // skip closing quote
+			}
+			unquoted, err := strconv.Unquote(`"` + quoted + `"`)
+			if err != nil {
+				unquoted = quoted
+			}
+			value = unquoted
+		} else {
+			valStart := i
+			for i < n && line[i] != ' ' {
+				i++
+			}
+			value = line[valStart:i]
+		}
+		fields[key] = value
+	}
+	if len(msgParts) > 0 {
+		fields["msg"] = strings.Join(msgParts, " ")
+	}
+	return fields
+}
@@ -0,0 +1,103 @@
+// 	Copyright 2017, Google, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want map[string]interface{}
+	}{
+		{
+			name: "key value pairs",
+			line: `level=warn msg="boom happened" code=42`,
+			want: map[string]interface{}{"level": "warn", "msg": "boom happened", "code": "42"},
+		},
+		{
+			name: "bare words become msg",
+			line: `starting up now level=info`,
+			want: map[string]interface{}{"msg": "starting up now", "level": "info"},
+		},
+		{
+			name: "escaped quote in value",
+			line: `msg="say \"hi\""`,
+			want: map[string]interface{}{"msg": `say "hi"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseLogfmt(tt.line)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLogfmt(%q) = %#v, want %#v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntryFromFields(t *testing.T) {
+	fields := map[string]interface{}{
+		"severity": "warn",
+		"time":     "2020-01-02T15:04:05Z",
+		"trace":    "trace-1",
+		"spanID":   "span-1",
+		"labels":   map[string]interface{}{"a": "b"},
+		"msg":      "hello",
+	}
+	sevMap := map[string]logging.Severity{"warn": logging.Warning}
+
+	entry := entryFromFields(fields, logging.Default, sevMap)
+
+	if entry.Severity != logging.Warning {
+		t.Errorf("Severity = %v, want %v", entry.Severity, logging.Warning)
+	}
+	if entry.Trace != "trace-1" {
+		t.Errorf("Trace = %q, want %q", entry.Trace, "trace-1")
+	}
+	if entry.SpanID != "span-1" {
+		t.Errorf("SpanID = %q, want %q", entry.SpanID, "span-1")
+	}
+	if entry.Timestamp.IsZero() {
+		t.Error("Timestamp is zero, want it parsed")
+	}
+	wantLabels := map[string]string{"a": "b"}
+	if !reflect.DeepEqual(entry.Labels, wantLabels) {
+		t.Errorf("Labels = %#v, want %#v", entry.Labels, wantLabels)
+	}
+	wantPayload := map[string]interface{}{"msg": "hello"}
+	if !reflect.DeepEqual(entry.Payload, wantPayload) {
+		t.Errorf("Payload = %#v, want %#v", entry.Payload, wantPayload)
+	}
+}
+
+func TestEntryFromFieldsUnparsableTimeIsLeftInPayload(t *testing.T) {
+	fields := map[string]interface{}{"time": "not-a-time", "msg": "hi"}
+	entry := entryFromFields(fields, logging.Default, nil)
+	if !entry.Timestamp.IsZero() {
+		t.Errorf("Timestamp = %v, want zero", entry.Timestamp)
+	}
+	payload, ok := entry.Payload.(map[string]interface{})
+	if !ok {
+		t.Fatalf("Payload = %#v, want map[string]interface{}", entry.Payload)
+	}
+	if payload["time"] != "not-a-time" {
+		t.Errorf("payload[time] = %v, want to be left intact", payload["time"])
+	}
+}
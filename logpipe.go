@@ -20,6 +20,7 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	flags "github.com/jessevdk/go-flags"
@@ -29,15 +30,51 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		os.Exit(runCommand(os.Args[2:]))
+	}
+
 	var opts struct {
-		ProjectID string `short:"p" long:"project" description:"Google Cloud Platform Project ID" required:"true"`
-		LogName   string `short:"l" long:"logname" description:"The name of the log to write to" default:"default"`
+		ProjectID           string        `short:"p" long:"project" description:"Google Cloud Platform Project ID; detected from GCE/GKE/Cloud Run metadata if omitted"`
+		LogName             string        `short:"l" long:"logname" description:"The name of the log to write to" default:"default"`
+		Format              string        `short:"f" long:"format" description:"Input format: text, json, or logfmt" default:"text" choice:"text" choice:"json" choice:"logfmt"`
+		Severity            string        `long:"severity" description:"Default severity for entries that don't specify one" default:"DEFAULT"`
+		SeverityMap         string        `long:"severity-map" description:"Comma-separated level=SEVERITY mappings applied to the severity/level field, e.g. warn=WARNING,err=ERROR"`
+		ResourceType        string        `long:"resource-type" description:"Override the auto-detected MonitoredResource type, e.g. gce_instance, k8s_container, cloud_run_revision"`
+		ResourceLabels      []string      `long:"resource-label" description:"Override or add a resource label as k=v; may be given multiple times"`
+		BufferSize          int           `long:"buffer-size" description:"Number of entries to buffer between stdin and the logging client before applying the overflow policy" default:"1000"`
+		FlushInterval       time.Duration `long:"flush-interval" description:"Maximum time entries may sit buffered before being sent" default:"1s"`
+		MaxEntrySize        int           `long:"max-entry-size" description:"Truncate a single entry's payload to this many bytes; 0 disables truncation" default:"262144"`
+		Overflow            string        `long:"overflow" description:"What to do when the buffer is full" default:"block" choice:"block" choice:"drop-oldest"`
+		EntryCountThreshold int           `long:"entry-count-threshold" description:"Flush after this many entries accumulate" default:"1000"`
+		EntryByteThreshold  int           `long:"entry-byte-threshold" description:"Flush after this many bytes of entries accumulate" default:"1048576"`
+		BufferedByteLimit   int           `long:"buffered-byte-limit" description:"Maximum bytes the logging client may buffer; entries past this limit are dropped and reported as overflow errors" default:"8388608"`
+		MultilineRegex      string        `long:"multiline-regex" description:"Regex matching lines that continue the previous record, e.g. leading whitespace or 'Caused by:'"`
+		Multiline           string        `long:"multiline" description:"Use a preset continuation pattern instead of --multiline-regex" choice:"java" choice:"python" choice:"go-panic"`
+		MultilineTimeout    time.Duration `long:"multiline-timeout" description:"Flush a pending multiline record if no continuation line arrives within this long" default:"200ms"`
+		Sinks               []string      `long:"sink" description:"Where to deliver entries: stackdriver, file:<path>, syslog, stdout, stderr, or dry-run; may be given multiple times" default:"stackdriver"`
 	}
 	_, err := flags.Parse(&opts)
 	if err != nil {
 		os.Exit(2)
 	}
 
+	defaultSeverity := logging.ParseSeverity(opts.Severity)
+	sevMap, err := parseSeverityMap(opts.SeverityMap)
+	if err != nil {
+		errorf("Invalid --severity-map: %v", err)
+	}
+
+	overflow, err := parseOverflowPolicy(opts.Overflow)
+	if err != nil {
+		errorf("%v", err)
+	}
+
+	mlRule, err := resolveMultilineRule(opts.MultilineRegex, opts.Multiline)
+	if err != nil {
+		errorf("Invalid --multiline-regex: %v", err)
+	}
+
 	// Check if Standard In is coming from a pipe
 	fi, err := os.Stdin.Stat()
 	if err != nil {
@@ -47,58 +84,149 @@ func main() {
 		errorf("Nothing is piped in so there is nothing to log!")
 	}
 
-	// Creates a client.
+	// Only stand up a Stackdriver client if a configured sink actually needs
+	// one; logpipe can run purely against file/syslog/stdout sinks.
+	var client *logging.Client
+	var logger *logging.Logger
 	ctx := context.Background()
-	client, err := logging.NewClient(ctx, opts.ProjectID)
-	if err != nil {
-		errorf("Failed to create client: %v", err)
-	}
-	errc := make(chan error)
-	client.OnError = func(err error) { errc <- err }
+	if needsStackdriver(opts.Sinks) {
+		projectID, err := resolveProjectID(opts.ProjectID)
+		if err != nil {
+			errorf("%v", err)
+		}
+		resource, commonLabels := detectResource(opts.ResourceType, opts.ResourceLabels)
+
+		client, err = logging.NewClient(ctx, projectID)
+		if err != nil {
+			errorf("Failed to create client: %v", err)
+		}
+		errc := make(chan error)
+		client.OnError = func(err error) { errc <- err }
+		go logErrors(errc)
+
+		pingCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+		defer cancel()
+		if err := client.Ping(pingCtx); err != nil {
+			errorf("Failed to ping logging service: %v", err)
+		}
 
-	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
-	defer cancel()
-	if err := client.Ping(ctx); err != nil {
-		errorf("Failed to ping logging service: %v", err)
+		// Selects the log to write to, with explicit buffering thresholds
+		// rather than the client's defaults so operators can tune them for
+		// their throughput and latency needs.
+		loggerOpts := []logging.LoggerOption{
+			logging.EntryCountThreshold(opts.EntryCountThreshold),
+			logging.EntryByteThreshold(opts.EntryByteThreshold),
+			logging.DelayThreshold(opts.FlushInterval),
+			logging.BufferedByteLimit(opts.BufferedByteLimit),
+		}
+		if resource != nil {
+			loggerOpts = append(loggerOpts, logging.CommonResource(resource))
+		}
+		if commonLabels != nil {
+			loggerOpts = append(loggerOpts, logging.CommonLabels(commonLabels))
+		}
+		logger = client.Logger(opts.LogName, loggerOpts...)
 	}
 
-	// Selects the log to write to.
-	logger := client.Logger(opts.LogName)
+	sinkList, err := newSinks(opts.Sinks, logger)
+	if err != nil {
+		errorf("%v", err)
+	}
+	sink := &fanOutSink{sinks: sinkList}
 
-	lines := make(chan string)
+	rawLines := make(chan string)
 	go func() {
-		defer close(lines)
-		// Read from Stdin and log it to Stdout and Stackdriver
-		s := bufio.NewScanner(io.TeeReader(os.Stdin, os.Stdout))
+		defer close(rawLines)
+		// Echo stdin back to stdout for the default "pipe passthrough" UX,
+		// unless a --sink stdout was explicitly configured: that sink
+		// already writes each (formatted) entry to stdout, so echoing the
+		// raw line too would print every line twice.
+		stdin := io.Reader(os.Stdin)
+		if !hasSink(opts.Sinks, "stdout") {
+			stdin = io.TeeReader(os.Stdin, os.Stdout)
+		}
+		s := bufio.NewScanner(stdin)
+		s.Buffer(make([]byte, 0, 64*1024), scannerMaxTokenSize(opts.MaxEntrySize))
 		for s.Scan() {
-			lines <- s.Text()
+			rawLines <- s.Text()
 		}
 		if err := s.Err(); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to scan input: %v\n", err)
 		}
 	}()
 
-	signals := make(chan os.Signal)
-	signal.Notify(signals, os.Interrupt)
-
-loop:
-	for {
-		select {
-		case line, ok := <-lines:
-			if !ok {
-				break loop
-			}
-			logger.Log(logging.Entry{Payload: line})
-		case s := <-signals:
-			fmt.Fprintf(os.Stderr, "Terminating program after receiving signal: %v\n", s)
-			break loop
+	var records <-chan string = rawLines
+	if mlRule != nil {
+		records = newMultilineAssembler(mlRule, opts.MultilineTimeout).run(rawLines)
+	}
+
+	queue := newEntryQueue(opts.BufferSize, overflow)
+	go func() {
+		defer queue.close()
+		for record := range records {
+			entry := parseEntry(opts.Format, record, defaultSeverity, sevMap)
+			queue.push(truncatePayload(entry, opts.MaxEntrySize))
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for entry := range queue.ch {
+			sink.Write(entry)
 		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case <-done:
+	case s := <-signals:
+		fmt.Fprintf(os.Stderr, "Terminating program after receiving signal: %v\n", s)
+	}
+
+	if n := queue.droppedCount(); n > 0 {
+		fmt.Fprintf(os.Stderr, "logpipe: %d entries dropped due to buffer overflow\n", n)
+	}
+
+	if err := sink.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "logpipe: sink close failed: %v\n", err)
 	}
 
 	// Closes the client and flushes the buffer to the Stackdriver Logging
 	// service.
-	if err := client.Close(); err != nil {
-		errorf("Failed to close client: %v", err)
+	if client != nil {
+		if err := client.Close(); err != nil {
+			errorf("Failed to close client: %v", err)
+		}
+	}
+}
+
+// needsStackdriver reports whether any configured sink spec requires a
+// Stackdriver logging client.
+func needsStackdriver(specs []string) bool {
+	return hasSink(specs, "stackdriver")
+}
+
+// hasSink reports whether spec appears verbatim among the configured sinks.
+func hasSink(specs []string, spec string) bool {
+	for _, s := range specs {
+		if s == spec {
+			return true
+		}
+	}
+	return false
+}
+
+// logErrors reports logging client errors to stderr, backing off with
+// jitter so a persistently failing backend doesn't flood the terminal while
+// the client retries in the background.
+func logErrors(errc <-chan error) {
+	b := newBackoff(100*time.Millisecond, 30*time.Second)
+	for err := range errc {
+		fmt.Fprintf(os.Stderr, "logpipe: logging error: %v\n", err)
+		time.Sleep(b.next())
 	}
 }
 
@@ -0,0 +1,141 @@
+// 	Copyright 2017, Google, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// multilineRule tells the assembler how to recognize a continuation line.
+// When start is set, a line matching it opens a block and every following
+// line is absorbed unconditionally until the block ends (timeout or EOF) or
+// another start line begins a new one; this suits stack traces whose body
+// lines (blank lines, bare function calls, file/line frames) have no single
+// shared shape. When start is nil, continuation alone decides whether each
+// line extends the current record, which is enough for formats where every
+// continuation line is self-identifying on its own, like Java's "\tat ...".
+type multilineRule struct {
+	start        *regexp.Regexp
+	continuation *regexp.Regexp
+}
+
+// multilinePresets are multiline rules for common stack trace formats.
+var multilinePresets = map[string]multilineRule{
+	"java": {continuation: regexp.MustCompile(`^(\s+at\s|\s*Caused by:|\s*\.\.\.\s+\d+\s+more)`)},
+	"python": {
+		start: regexp.MustCompile(`^Traceback \(most recent call last\):`),
+	},
+	"go-panic": {
+		start: regexp.MustCompile(`^panic:\s`),
+	},
+}
+
+// resolveMultilineRule turns the --multiline-regex/--multiline flags into a
+// multilineRule, or nil if multiline assembly is disabled. An explicit
+// --multiline-regex is always treated as a plain continuation pattern.
+func resolveMultilineRule(explicit, preset string) (*multilineRule, error) {
+	if explicit != "" {
+		re, err := regexp.Compile(explicit)
+		if err != nil {
+			return nil, err
+		}
+		return &multilineRule{continuation: re}, nil
+	}
+	if preset == "" {
+		return nil, nil
+	}
+	rule, ok := multilinePresets[preset]
+	if !ok {
+		return nil, fmt.Errorf("unknown --multiline preset %q", preset)
+	}
+	return &rule, nil
+}
+
+// multilineAssembler coalesces consecutive lines matching a continuation
+// rule into a single record, flushing the pending record whenever the rule
+// says the record is done, the timeout elapses without a new line, or the
+// input ends.
+type multilineAssembler struct {
+	rule    *multilineRule
+	timeout time.Duration
+}
+
+func newMultilineAssembler(rule *multilineRule, timeout time.Duration) *multilineAssembler {
+	return &multilineAssembler{rule: rule, timeout: timeout}
+}
+
+// run reads lines, assembles multiline records, and returns a channel of
+// completed records. It closes the returned channel once in is closed and
+// any pending record has been flushed.
+func (a *multilineAssembler) run(in <-chan string) <-chan string {
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		var pending []string
+		inBlock := false
+		timer := time.NewTimer(a.timeout)
+		if !timer.Stop() {
+			<-timer.C
+		}
+		flush := func() {
+			if len(pending) == 0 {
+				return
+			}
+			out <- strings.Join(pending, "\n")
+			pending = nil
+		}
+		stopTimer := func() {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+		for {
+			select {
+			case line, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				stopTimer()
+				var continues bool
+				if a.rule.start != nil {
+					isStart := a.rule.start.MatchString(line)
+					continues = inBlock && !isStart
+					if !continues {
+						inBlock = isStart
+					}
+				} else {
+					continues = len(pending) > 0 && a.rule.continuation.MatchString(line)
+				}
+				if continues {
+					pending = append(pending, line)
+				} else {
+					flush()
+					pending = []string{line}
+				}
+				timer.Reset(a.timeout)
+			case <-timer.C:
+				flush()
+				inBlock = false
+			}
+		}
+	}()
+	return out
+}
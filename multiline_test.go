@@ -0,0 +1,130 @@
+// 	Copyright 2017, Google, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// assemble feeds lines through a multilineAssembler for the named preset and
+// returns the completed records. The timeout is set long enough that it
+// never fires during the test; EOF alone flushes the last pending record.
+func assemble(t *testing.T, preset string, lines []string) []string {
+	t.Helper()
+	rule, err := resolveMultilineRule("", preset)
+	if err != nil {
+		t.Fatalf("resolveMultilineRule(%q): %v", preset, err)
+	}
+	in := make(chan string)
+	out := newMultilineAssembler(rule, time.Hour).run(in)
+	go func() {
+		for _, line := range lines {
+			in <- line
+		}
+		close(in)
+	}()
+	var records []string
+	for record := range out {
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestMultilinePresetGoPanic(t *testing.T) {
+	lines := strings.Split(strings.TrimRight(`some unrelated log line
+panic: boom
+
+goroutine 1 [running]:
+main.main()
+	/tmp/x.go:10 +0x25
+exit status 2`, "\n"), "\n")
+
+	records := assemble(t, "go-panic", lines)
+	want := []string{
+		"some unrelated log line",
+		strings.Join(lines[1:], "\n"),
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d: %q", len(records), len(want), records)
+	}
+	for i, r := range records {
+		if r != want[i] {
+			t.Errorf("record %d = %q, want %q", i, r, want[i])
+		}
+	}
+}
+
+func TestMultilinePresetPython(t *testing.T) {
+	lines := []string{
+		`Traceback (most recent call last):`,
+		`  File "x.py", line 10, in <module>`,
+		`    foo()`,
+		`  File "x.py", line 5, in foo`,
+		`    raise ValueError("boom")`,
+		`ValueError: boom`,
+	}
+
+	records := assemble(t, "python", lines)
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1: %q", len(records), records)
+	}
+	want := strings.Join(lines, "\n")
+	if records[0] != want {
+		t.Errorf("record = %q, want %q", records[0], want)
+	}
+}
+
+func TestMultilinePresetJava(t *testing.T) {
+	lines := []string{
+		"java.lang.RuntimeException: boom",
+		"\tat com.example.Foo.bar(Foo.java:10)",
+		"\tat com.example.Main.main(Main.java:5)",
+		"Caused by: java.lang.NullPointerException",
+		"\t... 3 more",
+	}
+
+	records := assemble(t, "java", lines)
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1: %q", len(records), records)
+	}
+	want := strings.Join(lines, "\n")
+	if records[0] != want {
+		t.Errorf("record = %q, want %q", records[0], want)
+	}
+}
+
+func TestMultilineTwoConsecutivePanics(t *testing.T) {
+	lines := []string{
+		`panic: first`,
+		`goroutine 1 [running]:`,
+		`panic: second`,
+		`goroutine 2 [running]:`,
+	}
+
+	records := assemble(t, "go-panic", lines)
+	want := []string{
+		strings.Join(lines[0:2], "\n"),
+		strings.Join(lines[2:4], "\n"),
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d: %q", len(records), len(want), records)
+	}
+	for i, r := range records {
+		if r != want[i] {
+			t.Errorf("record %d = %q, want %q", i, r, want[i])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// 	Copyright 2017, Google, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/compute/metadata"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// resolveProjectID returns the explicit project ID if one was given,
+// otherwise falls back to the ambient GCE/GKE/Cloud Run metadata server.
+func resolveProjectID(explicit string) (string, error) {
+	if explicit != "" {
+		return explicit, nil
+	}
+	if !metadata.OnGCE() {
+		return "", fmt.Errorf("no --project given and not running on GCE; pass --project explicitly")
+	}
+	return metadata.ProjectID()
+}
+
+// detectResource figures out the MonitoredResource and common labels to
+// attach to every entry, based on where logpipe is running. resourceType
+// and resourceLabels override or augment auto-detection when set.
+func detectResource(resourceType string, resourceLabels []string) (*mrpb.MonitoredResource, map[string]string) {
+	overrides, err := parseLabelFlags(resourceLabels)
+	if err != nil {
+		errorf("Invalid --resource-label: %v", err)
+	}
+
+	if resourceType != "" {
+		return &mrpb.MonitoredResource{Type: resourceType, Labels: overrides}, nil
+	}
+
+	if !metadata.OnGCE() {
+		return nil, nil
+	}
+
+	switch {
+	case onCloudRun():
+		return cloudRunResource(overrides), nil
+	case onGKE():
+		return gkeResource(overrides), nil
+	default:
+		return gceResource(overrides), gceCommonLabels()
+	}
+}
+
+func onCloudRun() bool {
+	return os.Getenv("K_SERVICE") != ""
+}
+
+func onGKE() bool {
+	_, err := metadata.InstanceAttributeValue("cluster-name")
+	return err == nil
+}
+
+func gceResource(overrides map[string]string) *mrpb.MonitoredResource {
+	zone, _ := metadata.Zone()
+	instanceID, _ := metadata.InstanceID()
+	labels := mergeLabels(map[string]string{
+		"zone":        zone,
+		"instance_id": instanceID,
+	}, overrides)
+	return &mrpb.MonitoredResource{Type: "gce_instance", Labels: labels}
+}
+
+func gceCommonLabels() map[string]string {
+	name, err := metadata.InstanceName()
+	if err != nil {
+		return nil
+	}
+	return map[string]string{"instance_name": name}
+}
+
+func gkeResource(overrides map[string]string) *mrpb.MonitoredResource {
+	zone, _ := metadata.Zone()
+	clusterName, _ := metadata.InstanceAttributeValue("cluster-name")
+	labels := mergeLabels(map[string]string{
+		"cluster_name":   strings.TrimSpace(clusterName),
+		"location":       zone,
+		"namespace_name": os.Getenv("NAMESPACE_NAME"),
+		"pod_name":       os.Getenv("POD_NAME"),
+		"container_name": os.Getenv("CONTAINER_NAME"),
+	}, overrides)
+	return &mrpb.MonitoredResource{Type: "k8s_container", Labels: labels}
+}
+
+func cloudRunResource(overrides map[string]string) *mrpb.MonitoredResource {
+	zone, _ := metadata.Zone()
+	labels := mergeLabels(map[string]string{
+		"service_name":       os.Getenv("K_SERVICE"),
+		"revision_name":      os.Getenv("K_REVISION"),
+		"configuration_name": os.Getenv("K_CONFIGURATION"),
+		"location":           zone,
+	}, overrides)
+	return &mrpb.MonitoredResource{Type: "cloud_run_revision", Labels: labels}
+}
+
+// mergeLabels returns a copy of base with overrides applied on top,
+// dropping any empty-valued entries from base.
+func mergeLabels(base, overrides map[string]string) map[string]string {
+	labels := map[string]string{}
+	for k, v := range base {
+		if v != "" {
+			labels[k] = v
+		}
+	}
+	for k, v := range overrides {
+		labels[k] = v
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// parseLabelFlags parses repeated "k=v" flag values into a map.
+func parseLabelFlags(flagValues []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, kv := range flagValues {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid label %q, want k=v", kv)
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
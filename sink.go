@@ -0,0 +1,274 @@
+// 	Copyright 2017, Google, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"log/syslog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+// Sink is anywhere a log entry can be delivered. logpipe fans every entry
+// out to one or more configured sinks rather than writing to Stackdriver
+// directly, so the same stream can also land in a file, syslog, or just
+// stdout/stderr for inspection.
+type Sink interface {
+	Write(e logging.Entry) error
+	Close() error
+}
+
+// newSinks builds the configured Sink for each --sink spec. Recognized
+// forms are "stackdriver", "file:<path>", "syslog", "stdout", "stderr", and
+// "dry-run". logger is used for the "stackdriver" sink and may be nil if it
+// wasn't requested.
+func newSinks(specs []string, logger *logging.Logger) ([]Sink, error) {
+	var sinks []Sink
+	for _, spec := range specs {
+		switch {
+		case spec == "stackdriver":
+			if logger == nil {
+				return nil, fmt.Errorf("sink %q requires a Stackdriver logger", spec)
+			}
+			sinks = append(sinks, &stackdriverSink{logger: logger})
+		case spec == "stdout":
+			sinks = append(sinks, &writerSink{w: os.Stdout})
+		case spec == "stderr":
+			sinks = append(sinks, &writerSink{w: os.Stderr})
+		case spec == "dry-run":
+			sinks = append(sinks, &dryRunSink{})
+		case spec == "syslog":
+			s, err := newSyslogSink()
+			if err != nil {
+				return nil, fmt.Errorf("sink %q: %v", spec, err)
+			}
+			sinks = append(sinks, s)
+		case strings.HasPrefix(spec, "file:"):
+			sinks = append(sinks, newFileSink(strings.TrimPrefix(spec, "file:")))
+		default:
+			return nil, fmt.Errorf("unknown sink %q", spec)
+		}
+	}
+	return sinks, nil
+}
+
+// fanOutSink writes every entry to each configured sink, reporting (but not
+// stopping on) per-sink errors.
+type fanOutSink struct {
+	sinks []Sink
+}
+
+func (f *fanOutSink) Write(e logging.Entry) error {
+	for _, s := range f.sinks {
+		if err := s.Write(e); err != nil {
+			fmt.Fprintf(os.Stderr, "logpipe: sink write failed: %v\n", err)
+		}
+	}
+	return nil
+}
+
+func (f *fanOutSink) Close() error {
+	var firstErr error
+	for _, s := range f.sinks {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// formatEntry renders a logging.Entry as a single human-readable line, for
+// sinks that aren't the Stackdriver API itself.
+func formatEntry(e logging.Entry) string {
+	ts := e.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	line := fmt.Sprintf("%s %s %v", ts.Format("2006-01-02T15:04:05.000Z07:00"), e.Severity, e.Payload)
+	if len(e.Labels) > 0 {
+		line += fmt.Sprintf(" labels=%v", e.Labels)
+	}
+	return line
+}
+
+// stackdriverSink delivers entries to Stackdriver Logging. This is
+// logpipe's original, and still default, behavior.
+type stackdriverSink struct {
+	logger *logging.Logger
+}
+
+func (s *stackdriverSink) Write(e logging.Entry) error {
+	s.logger.Log(e)
+	return nil
+}
+
+func (s *stackdriverSink) Close() error { return nil }
+
+// writerSink writes formatted entries to an *os.File, used for
+// the stdout/stderr passthrough sinks.
+type writerSink struct {
+	w *os.File
+}
+
+func (s *writerSink) Write(e logging.Entry) error {
+	_, err := fmt.Fprintln(s.w, formatEntry(e))
+	return err
+}
+
+func (s *writerSink) Close() error { return nil }
+
+// dryRunSink only echoes what would have been sent, so --format and
+// --severity-map can be exercised without a GCP project.
+type dryRunSink struct{}
+
+func (s *dryRunSink) Write(e logging.Entry) error {
+	fmt.Fprintf(os.Stderr, "[dry-run] %s\n", formatEntry(e))
+	return nil
+}
+
+func (s *dryRunSink) Close() error { return nil }
+
+// syslogSink delivers entries to the local syslog daemon, mapping
+// Stackdriver severities onto syslog priorities.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink() (*syslogSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO, "logpipe")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) Write(e logging.Entry) error {
+	msg := formatEntry(e)
+	switch severityToSyslogLevel(e.Severity) {
+	case syslogCrit:
+		return s.w.Crit(msg)
+	case syslogErr:
+		return s.w.Err(msg)
+	case syslogWarning:
+		return s.w.Warning(msg)
+	case syslogInfo:
+		return s.w.Info(msg)
+	default:
+		return s.w.Debug(msg)
+	}
+}
+
+// syslogLevel names the syslog.Writer method a severityToSyslogLevel result
+// maps to, split out from syslogSink.Write so the mapping is testable
+// without a live syslog connection.
+type syslogLevel int
+
+const (
+	syslogDebug syslogLevel = iota
+	syslogInfo
+	syslogWarning
+	syslogErr
+	syslogCrit
+)
+
+func severityToSyslogLevel(sev logging.Severity) syslogLevel {
+	switch {
+	case sev >= logging.Critical:
+		return syslogCrit
+	case sev >= logging.Error:
+		return syslogErr
+	case sev >= logging.Warning:
+		return syslogWarning
+	case sev >= logging.Info:
+		return syslogInfo
+	default:
+		return syslogDebug
+	}
+}
+
+func (s *syslogSink) Close() error { return s.w.Close() }
+
+// fileSink is a rotating file sink: when the next line would take the file
+// past maxSize bytes, it's rotated to a single ".1" backup and a fresh file
+// is started.
+type fileSink struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	size    int64
+	f       *os.File
+}
+
+const fileSinkMaxSize = 100 * 1024 * 1024 // 100MB
+
+func newFileSink(path string) *fileSink {
+	return &fileSink{path: path, maxSize: fileSinkMaxSize}
+}
+
+func (s *fileSink) Write(e logging.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	line := formatEntry(e) + "\n"
+	if s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.WriteString(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
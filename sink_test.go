@@ -0,0 +1,246 @@
+// 	Copyright 2017, Google, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/logging"
+)
+
+func TestNewSinksUnknownSpec(t *testing.T) {
+	if _, err := newSinks([]string{"bogus"}, nil); err == nil {
+		t.Error("newSinks with an unknown spec: got nil error, want one")
+	}
+}
+
+func TestNewSinksStackdriverRequiresLogger(t *testing.T) {
+	if _, err := newSinks([]string{"stackdriver"}, nil); err == nil {
+		t.Error("newSinks([\"stackdriver\"], nil): got nil error, want one")
+	}
+}
+
+func TestNewSinksFilePrefix(t *testing.T) {
+	sinks, err := newSinks([]string{"file:/tmp/x.log"}, nil)
+	if err != nil {
+		t.Fatalf("newSinks: %v", err)
+	}
+	if len(sinks) != 1 {
+		t.Fatalf("len(sinks) = %d, want 1", len(sinks))
+	}
+	fs, ok := sinks[0].(*fileSink)
+	if !ok {
+		t.Fatalf("sinks[0] = %T, want *fileSink", sinks[0])
+	}
+	if fs.path != "/tmp/x.log" {
+		t.Errorf("path = %q, want %q", fs.path, "/tmp/x.log")
+	}
+}
+
+func TestNewSinksStdoutStderrDryRun(t *testing.T) {
+	sinks, err := newSinks([]string{"stdout", "stderr", "dry-run"}, nil)
+	if err != nil {
+		t.Fatalf("newSinks: %v", err)
+	}
+	if len(sinks) != 3 {
+		t.Fatalf("len(sinks) = %d, want 3", len(sinks))
+	}
+	if _, ok := sinks[0].(*writerSink); !ok {
+		t.Errorf("sinks[0] = %T, want *writerSink", sinks[0])
+	}
+	if _, ok := sinks[1].(*writerSink); !ok {
+		t.Errorf("sinks[1] = %T, want *writerSink", sinks[1])
+	}
+	if _, ok := sinks[2].(*dryRunSink); !ok {
+		t.Errorf("sinks[2] = %T, want *dryRunSink", sinks[2])
+	}
+}
+
+// fakeSink records every entry it's given and can be told to fail.
+type fakeSink struct {
+	written   []logging.Entry
+	writeErr  error
+	closeErr  error
+	closeCall int
+}
+
+func (f *fakeSink) Write(e logging.Entry) error {
+	f.written = append(f.written, e)
+	return f.writeErr
+}
+
+func (f *fakeSink) Close() error {
+	f.closeCall++
+	return f.closeErr
+}
+
+func TestFanOutSinkWritesToEvery(t *testing.T) {
+	a := &fakeSink{}
+	b := &fakeSink{writeErr: errors.New("boom")}
+	f := &fanOutSink{sinks: []Sink{a, b}}
+
+	entry := logging.Entry{Payload: "hi"}
+	if err := f.Write(entry); err != nil {
+		t.Errorf("Write() = %v, want nil (per-sink errors are reported, not returned)", err)
+	}
+	if len(a.written) != 1 || a.written[0].Payload != "hi" {
+		t.Errorf("sink a.written = %#v, want one entry with payload %q", a.written, "hi")
+	}
+	if len(b.written) != 1 {
+		t.Errorf("sink b.written = %#v, want one entry despite its Write error", b.written)
+	}
+}
+
+func TestFanOutSinkCloseReturnsFirstError(t *testing.T) {
+	first := errors.New("first")
+	second := errors.New("second")
+	a := &fakeSink{closeErr: first}
+	b := &fakeSink{closeErr: second}
+	f := &fanOutSink{sinks: []Sink{a, b}}
+
+	if err := f.Close(); err != first {
+		t.Errorf("Close() = %v, want %v", err, first)
+	}
+	if a.closeCall != 1 || b.closeCall != 1 {
+		t.Errorf("close calls = %d, %d, want both sinks closed once", a.closeCall, b.closeCall)
+	}
+}
+
+func TestWriterSink(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	sink := &writerSink{w: w}
+	if err := sink.Write(logging.Entry{Payload: "hello", Severity: logging.Warning}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	w.Close()
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading pipe: %v", err)
+	}
+	if !strings.Contains(line, "hello") || !strings.Contains(line, "Warning") {
+		t.Errorf("line = %q, want it to mention the payload and severity", line)
+	}
+}
+
+func TestDryRunSink(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("os.Pipe: %v", err)
+	}
+	origStderr := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = origStderr }()
+
+	sink := &dryRunSink{}
+	if err := sink.Write(logging.Entry{Payload: "hello"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	w.Close()
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading pipe: %v", err)
+	}
+	if !strings.HasPrefix(line, "[dry-run] ") {
+		t.Errorf("line = %q, want it prefixed with [dry-run]", line)
+	}
+	if !strings.Contains(line, "hello") {
+		t.Errorf("line = %q, want it to mention the payload", line)
+	}
+}
+
+func TestSeverityToSyslogLevel(t *testing.T) {
+	tests := []struct {
+		sev  logging.Severity
+		want syslogLevel
+	}{
+		{logging.Default, syslogDebug},
+		{logging.Info, syslogInfo},
+		{logging.Warning, syslogWarning},
+		{logging.Error, syslogErr},
+		{logging.Critical, syslogCrit},
+		{logging.Emergency, syslogCrit},
+	}
+	for _, tt := range tests {
+		if got := severityToSyslogLevel(tt.sev); got != tt.want {
+			t.Errorf("severityToSyslogLevel(%v) = %v, want %v", tt.sev, got, tt.want)
+		}
+	}
+}
+
+func TestFileSinkWriteAndRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.log")
+	s := &fileSink{path: path, maxSize: 40}
+
+	for i := 0; i < 3; i++ {
+		e := logging.Entry{Payload: fmt.Sprintf("entry-%d", i)}
+		if err := s.Write(e); err != nil {
+			t.Fatalf("Write(%d): %v", i, err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("expected a rotated backup at %s: %v", path+".1", err)
+	}
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading current log: %v", err)
+	}
+	if !strings.Contains(string(current), "entry-2") {
+		t.Errorf("current log = %q, want it to contain the most recent entry", current)
+	}
+}
+
+func TestFileSinkReopenPreservesSize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.log")
+
+	s1 := &fileSink{path: path, maxSize: fileSinkMaxSize}
+	if err := s1.Write(logging.Entry{Payload: "first"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := s1.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s2 := &fileSink{path: path, maxSize: fileSinkMaxSize}
+	if err := s2.Write(logging.Entry{Payload: "second"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := s2.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size() != s2.size {
+		t.Errorf("file size = %d, fileSink thinks size = %d; reopening didn't pick up the existing size", info.Size(), s2.size)
+	}
+}